@@ -0,0 +1,211 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/golang/glog"
+	"github.com/kdomanski/iso9660"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	providerconfigv1 "github.com/openshift/cluster-api-provider-libvirt/pkg/apis/libvirtproviderconfig/v1beta1"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// cloudInitISOLabel is the volume label cloud-init's NoCloud datasource
+// looks for on an attached CDROM, per
+// https://cloudinit.readthedocs.io/en/latest/reference/datasources/nocloud.html
+const cloudInitISOLabel = "cidata"
+
+func setCloudInit(domainDef *libvirtxml.Domain, client *libvirtClient, cloudInit *providerconfigv1.CloudInit, machine metav1.Object, kubeClient kubernetes.Interface, machineNamespace, volumeName string) error {
+	glog.Info("Creating cloud-init NoCloud volume")
+	cloudInitDef := newCloudInitDef()
+
+	if cloudInit.UserDataSecret == "" {
+		return fmt.Errorf("cloudInit.userDataSecret not set")
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets(machineNamespace).Get(cloudInit.UserDataSecret, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("can not retrieve user data secret '%v/%v' when constructing cloud-init volume: %v", machineNamespace, cloudInit.UserDataSecret, err)
+	}
+
+	userData, err := renderCloudInitUserData(secret.Data)
+	if err != nil {
+		return fmt.Errorf("failed to render cloud-init user-data for secret '%v/%v': %v", machineNamespace, cloudInit.UserDataSecret, err)
+	}
+
+	cloudInitDef.Name = volumeName
+	cloudInitDef.PoolName = client.poolName
+	cloudInitDef.MetaData = renderCloudInitMetaData(machine)
+	cloudInitDef.UserData = userData
+	cloudInitDef.NetworkConfig = string(secret.Data["network-config"])
+
+	isoVolumeName, err := cloudInitDef.createAndUpload(client)
+	if err != nil {
+		return err
+	}
+
+	domainDef.Devices.Disks = append(domainDef.Devices.Disks, libvirtxml.DomainDisk{
+		Device: "cdrom",
+		Driver: &libvirtxml.DomainDiskDriver{
+			Name: "qemu",
+			Type: "raw",
+		},
+		Source: &libvirtxml.DomainDiskSource{
+			File: &libvirtxml.DomainDiskSourceFile{
+				File: isoVolumeName,
+			},
+		},
+		Target: &libvirtxml.DomainDiskTarget{
+			Dev: "sdb",
+			Bus: "sata",
+		},
+		ReadOnly: &libvirtxml.DomainDiskReadOnly{},
+	})
+
+	return nil
+}
+
+// cloudConfigUserData is the structured subset of a cloud-init user-data
+// secret this provider understands. A raw "user-data" key always wins
+// over these fields so callers that already author a full #cloud-config
+// document aren't forced to restructure it.
+type cloudConfigUserData struct {
+	SSHAuthorizedKeys []string      `yaml:"ssh_authorized_keys,omitempty"`
+	Hostname          string        `yaml:"hostname,omitempty"`
+	Runcmd            []interface{} `yaml:"runcmd,omitempty"`
+}
+
+// renderCloudInitUserData returns the final #cloud-config document:
+// secretData["user-data"] verbatim if present, otherwise a document
+// rendered from the structured sshAuthorizedKeys/hostname/runcmd keys.
+func renderCloudInitUserData(secretData map[string][]byte) (string, error) {
+	if raw, ok := secretData["user-data"]; ok {
+		return string(raw), nil
+	}
+
+	cfg := cloudConfigUserData{
+		Hostname: string(secretData["hostname"]),
+	}
+	if err := yaml.Unmarshal(secretData["sshAuthorizedKeys"], &cfg.SSHAuthorizedKeys); err != nil && len(secretData["sshAuthorizedKeys"]) > 0 {
+		return "", errors.Wrap(err, "invalid sshAuthorizedKeys in user data secret")
+	}
+	if err := yaml.Unmarshal(secretData["runcmd"], &cfg.Runcmd); err != nil && len(secretData["runcmd"]) > 0 {
+		return "", errors.Wrap(err, "invalid runcmd in user data secret")
+	}
+
+	rendered, err := yaml.Marshal(cfg)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to render #cloud-config from structured user data")
+	}
+	return "#cloud-config\n" + string(rendered), nil
+}
+
+// renderCloudInitMetaData populates instance-id from the Machine's UID
+// and local-hostname from its name, so cloud-init's per-instance state
+// (e.g. re-running user-data) tracks VM rebuilds correctly instead of
+// being keyed off a reused volume name.
+func renderCloudInitMetaData(machine metav1.Object) string {
+	metaData := map[string]string{
+		"instance-id":    string(machine.GetUID()),
+		"local-hostname": machine.GetName(),
+	}
+	out, err := yaml.Marshal(metaData)
+	if err != nil {
+		// metaData only contains strings, so this can't realistically fail.
+		glog.Errorf("failed to render cloud-init meta-data: %v", err)
+		return ""
+	}
+	return string(out)
+}
+
+// defCloudInit holds the rendered contents of a cloud-init NoCloud ISO
+// before it's built and uploaded to the libvirt pool.
+type defCloudInit struct {
+	Name          string
+	PoolName      string
+	MetaData      string
+	UserData      string
+	NetworkConfig string
+}
+
+// newCloudInitDef returns a new defCloudInit with the defaults the
+// provider uses.
+func newCloudInitDef() defCloudInit {
+	return defCloudInit{}
+}
+
+// createAndUpload builds a NoCloud ISO from the CloudInit instance and
+// uploads it to the libvirt pool, returning the uploaded volume's name.
+func (ci *defCloudInit) createAndUpload(client *libvirtClient) (string, error) {
+	volumeDef := newDefVolume(ci.Name)
+
+	isoFile, err := ci.createISO()
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err := os.Remove(isoFile); err != nil {
+			glog.Infof("Error while removing tmp cloud-init ISO file: %s", err)
+		}
+	}()
+
+	img, err := newImage(isoFile)
+	if err != nil {
+		return "", err
+	}
+
+	size, err := img.size()
+	if err != nil {
+		return "", err
+	}
+
+	volumeDef.Capacity.Unit = "B"
+	volumeDef.Capacity.Value = size
+	volumeDef.Target.Format.Type = "raw"
+
+	return uploadVolume(ci.PoolName, client, volumeDef, img)
+}
+
+// createISO writes user-data, meta-data and (optionally) network-config
+// to a NoCloud-labelled ISO9660 image in a temporary file and returns its
+// path.
+func (ci *defCloudInit) createISO() (string, error) {
+	glog.Info("Creating cloud-init NoCloud ISO")
+
+	writer, err := iso9660.NewWriter()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create ISO writer")
+	}
+	defer writer.Cleanup()
+
+	if err := writer.AddFile(strings.NewReader(ci.UserData), "user-data"); err != nil {
+		return "", errors.Wrap(err, "failed to add user-data to cloud-init ISO")
+	}
+	if err := writer.AddFile(strings.NewReader(ci.MetaData), "meta-data"); err != nil {
+		return "", errors.Wrap(err, "failed to add meta-data to cloud-init ISO")
+	}
+	if ci.NetworkConfig != "" {
+		if err := writer.AddFile(strings.NewReader(ci.NetworkConfig), "network-config"); err != nil {
+			return "", errors.Wrap(err, "failed to add network-config to cloud-init ISO")
+		}
+	}
+
+	isoFile, err := ioutil.TempFile("", ci.Name)
+	if err != nil {
+		return "", errors.Wrap(err, "cannot create tmp file for cloud-init ISO")
+	}
+	defer isoFile.Close()
+
+	if err := writer.WriteTo(isoFile, cloudInitISOLabel); err != nil {
+		return "", errors.Wrap(err, "failed to write cloud-init ISO")
+	}
+
+	return isoFile.Name(), nil
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRenderCloudInitUserDataRawTakesPrecedence(t *testing.T) {
+	secretData := map[string][]byte{
+		"user-data": []byte("#cloud-config\nhostname: from-raw\n"),
+		"hostname":  []byte("from-structured"),
+	}
+
+	got, err := renderCloudInitUserData(secretData)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "#cloud-config\nhostname: from-raw\n" {
+		t.Fatalf("expected the raw user-data key to win verbatim, got %q", got)
+	}
+}
+
+func TestRenderCloudInitUserDataStructuredFields(t *testing.T) {
+	secretData := map[string][]byte{
+		"hostname":          []byte("worker-0"),
+		"sshAuthorizedKeys": []byte("- ssh-rsa AAAA\n"),
+		"runcmd":            []byte("- [ \"echo\", \"hi\" ]\n"),
+	}
+
+	got, err := renderCloudInitUserData(secretData)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(got, "#cloud-config\n") {
+		t.Fatalf("expected rendered user-data to start with the #cloud-config header, got %q", got)
+	}
+	if !strings.Contains(got, "worker-0") {
+		t.Fatalf("expected rendered user-data to contain the hostname, got %q", got)
+	}
+	if !strings.Contains(got, "ssh-rsa AAAA") {
+		t.Fatalf("expected rendered user-data to contain the ssh key, got %q", got)
+	}
+}
+
+func TestRenderCloudInitUserDataInvalidSSHAuthorizedKeys(t *testing.T) {
+	secretData := map[string][]byte{
+		"sshAuthorizedKeys": []byte("not: a-list"),
+	}
+
+	if _, err := renderCloudInitUserData(secretData); err == nil {
+		t.Fatalf("expected an error for invalid sshAuthorizedKeys YAML")
+	}
+}
+
+func TestRenderCloudInitUserDataInvalidRuncmd(t *testing.T) {
+	secretData := map[string][]byte{
+		"runcmd": []byte("not: a-list"),
+	}
+
+	if _, err := renderCloudInitUserData(secretData); err == nil {
+		t.Fatalf("expected an error for invalid runcmd YAML")
+	}
+}
+
+func TestRenderCloudInitMetaData(t *testing.T) {
+	machine := &metav1.ObjectMeta{
+		Name: "worker-0",
+		UID:  types.UID("1234-5678"),
+	}
+
+	got := renderCloudInitMetaData(machine)
+
+	if !strings.Contains(got, "instance-id: 1234-5678") {
+		t.Fatalf("expected instance-id to come from the machine UID, got %q", got)
+	}
+	if !strings.Contains(got, "local-hostname: worker-0") {
+		t.Fatalf("expected local-hostname to come from the machine name, got %q", got)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"runtime"
 	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -19,7 +20,7 @@ import (
 	"github.com/pkg/errors"
 )
 
-func setIgnition(domainDef *libvirtxml.Domain, client *libvirtClient, ignition *providerconfigv1.Ignition, kubeClient kubernetes.Interface, machineNamespace, volumeName string) error {
+func setIgnition(domainDef *libvirtxml.Domain, client *libvirtClient, ignition *providerconfigv1.Ignition, kubeClient kubernetes.Interface, machineNamespace, machineUID, volumeName string) error {
 	glog.Info("Creating ignition file")
 	ignitionDef := newIgnitionDef()
 
@@ -36,9 +37,18 @@ func setIgnition(domainDef *libvirtxml.Domain, client *libvirtClient, ignition *
 		return fmt.Errorf("can not retrieve user data secret '%v/%v' when constructing cloud init volume: key 'userData' not found in the secret", machineNamespace, ignition.UserDataSecret)
 	}
 
+	content, err := renderIgnitionContent(string(userDataSecret), ignition)
+	if err != nil {
+		return fmt.Errorf("failed to render ignition config for secret '%v/%v': %v", machineNamespace, ignition.UserDataSecret, err)
+	}
+
+	if ignition.DeliveryMode == providerconfigv1.IgnitionDeliveryHTTP {
+		return setIgnitionHTTP(domainDef, client.ignitionServer, &fwCfgInjector{}, ignitionTargetPath(ignition), machineUID, content)
+	}
+
 	ignitionDef.Name = volumeName
 	ignitionDef.PoolName = client.poolName
-	ignitionDef.Content = string(userDataSecret)
+	ignitionDef.Content = content
 
 	glog.Infof("Ignition: %+v", ignitionDef)
 
@@ -47,21 +57,10 @@ func setIgnition(domainDef *libvirtxml.Domain, client *libvirtClient, ignition *
 		return err
 	}
 
-	domainDef.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{
-		Args: []libvirtxml.DomainQEMUCommandlineArg{
-			{
-				// https://github.com/qemu/qemu/blob/master/docs/specs/fw_cfg.txt
-				Value: "-fw_cfg",
-			},
-			{
-				Value: fmt.Sprintf("name=opt/com.coreos/config,file=%s", ignitionVolumeName),
-			},
-		},
-	}
-	return nil
+	return (&fwCfgInjector{}).Inject(domainDef, ignitionVolumeName, ignitionTargetPath(ignition))
 }
 
-func setIgnitionForS390X(domainDef *libvirtxml.Domain, client *libvirtClient, ignition *providerconfigv1.Ignition, kubeClient kubernetes.Interface, machineNamespace, volumeName string) error {
+func setIgnitionForS390X(domainDef *libvirtxml.Domain, client *libvirtClient, ignition *providerconfigv1.Ignition, kubeClient kubernetes.Interface, machineNamespace, machineUID, volumeName string) error {
 	glog.Info("Creating ignition file for s390x")
 	ignitionDef := newIgnitionDef()
 
@@ -78,118 +77,35 @@ func setIgnitionForS390X(domainDef *libvirtxml.Domain, client *libvirtClient, ig
 		return fmt.Errorf("can not retrieve user data secret '%v/%v' when constructing cloud init volume: key 'userData' not found in the secret", machineNamespace, ignition.UserDataSecret)
 	}
 
-	ignitionDef.Name = volumeName
-	ignitionDef.PoolName = client.poolName
-	ignitionDef.Content = string(userDataSecret)
-
-	glog.Infof("Ignition: %+v", ignitionDef)
-
-	ignitionVolumeName, err := ignitionDef.createAndUpload(client)
+	content, err := renderIgnitionContent(string(userDataSecret), ignition)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to render ignition config for secret '%v/%v': %v", machineNamespace, ignition.UserDataSecret, err)
 	}
 
-	// _fw_cfg isn't supported on s390x, so we use guestfish to inject the ignition for now
-	return injectIgnitionByGuestfish(domainDef, ignitionVolumeName)
-}
-
-func injectIgnitionByGuestfish(domainDef *libvirtxml.Domain, ignitionFile string) error {
-	glog.Info("Injecting ignition configuration using guestfish")
-
-	/*
-	 * Add the image into guestfish, execute the following command,
-	 *     guestfish --listen -a ${volumeFilePath}
-	 *
-	 * output example:
-	 *  	   GUESTFISH_PID=4513; export GUESTFISH_PID
-	 */
-	args := []string{"--listen", "-a", domainDef.Devices.Disks[0].Source.File.File}
-	output, err := startCmd(true, nil, args...)
+	// _fw_cfg isn't supported on s390x, so both the raw ignition volume and
+	// the HTTP delivery pointer need to go through the guest-filesystem
+	// injector instead.
+	injector, err := ignitionInjectorForArch(runtime.GOARCH, ignition.InjectionBackend)
 	if err != nil {
 		return err
 	}
 
-	strArray := strings.Split(output, ";")
-	if len(strArray) != 2 {
-		return fmt.Errorf("Invalid output when starting guestfish: %s", output)
-	}
-	strArray1 := strings.Split(strArray[0], "=")
-	if len(strArray1) != 2 {
-		return fmt.Errorf("failed to get the guestfish PID from %s", output)
-	}
-	env := []string{strArray[0]}
-
-	/*
-	 * Launch guestfish, execute the following command,
-	 *     guestfish --remote -- run
-	 */
-	args = []string{"--remote", "--", "run"}
-	_, err = execCmd(true, env, args...)
-	if err != nil {
-		return err
+	if ignition.DeliveryMode == providerconfigv1.IgnitionDeliveryHTTP {
+		return setIgnitionHTTP(domainDef, client.ignitionServer, injector, ignitionTargetPath(ignition), machineUID, content)
 	}
 
-	/*
-	 * Get the boot filesystem, execute the following command,
-	 *     findfs-label boot
-	 *
-	 *	output example:
-	 *		/dev/sda1
-	 */
-	args = []string{"--remote", "--", "findfs-label", "boot"}
-	output, err = execCmd(true, env, args...)
-	if err != nil {
-		return err
-	}
-
-	bootDisk := strings.TrimSpace(output)
-	if len(bootDisk) == 0 {
-		return fmt.Errorf("failed to get the boot filesystem")
-	}
-
-	/*
-	 * Mount the boot filesystem, execute the following command,
-	 *     guestfish --remote -- mount ${boot_filesystem} /
-	 */
-	args = []string{"--remote", "--", "mount", bootDisk, "/"}
-	_, err = execCmd(true, env, args...)
-	if err != nil {
-		return err
-	}
-
-	/*
-	 * Upload the ignition file, execute the following command,
-	 *     guestfish --remote -- upload ${ignition_filepath} /ignition/config.ign
-	 *
-	 * The target path is hard coded as "/ignition/config.ign" for now
-	 */
-	args = []string{"--remote", "--", "upload", ignitionFile, "/ignition/config.ign"}
-	_, err = execCmd(true, env, args...)
-	if err != nil {
-		return err
-	}
+	ignitionDef.Name = volumeName
+	ignitionDef.PoolName = client.poolName
+	ignitionDef.Content = content
 
-	/*
-	 * Umount all filesystems, execute the following command,
-	 *     guestfish --remote -- umount-all
-	 */
-	args = []string{"--remote", "--", "umount-all"}
-	_, err = execCmd(true, env, args...)
-	if err != nil {
-		return err
-	}
+	glog.Infof("Ignition: %+v", ignitionDef)
 
-	/*
-	 * Exit guestfish, execute the following command,
-	 *     guestfish --remote -- exit
-	 */
-	args = []string{"--remote", "--", "exit"}
-	_, err = execCmd(true, env, args...)
+	ignitionVolumeName, err := ignitionDef.createAndUpload(client)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return injector.Inject(domainDef, ignitionVolumeName, ignitionTargetPath(ignition))
 }
 
 func execCmd(useRoot bool, env []string, args ...string) (string, error) {
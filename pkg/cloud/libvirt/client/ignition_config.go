@@ -0,0 +1,341 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	providerconfigv1 "github.com/openshift/cluster-api-provider-libvirt/pkg/apis/libvirtproviderconfig/v1beta1"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ignitionSpecVersion identifies which major revision of the Ignition
+// config schema a payload uses. Spec 2.x and 3.x differ in their merge
+// semantics, so callers need to know which one they're dealing with
+// before attempting to overlay provider-injected fragments.
+type ignitionSpecVersion int
+
+const (
+	ignitionSpecUnknown ignitionSpecVersion = iota
+	ignitionSpecV2
+	ignitionSpecV3
+)
+
+// ignitionConfig is a minimal internal representation of an Ignition
+// config, covering only the fields this provider needs to inspect,
+// validate or merge. It intentionally does not attempt to model the
+// full Ignition schema.
+type ignitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+		Config  struct {
+			Merge []struct {
+				Source string `json:"source"`
+			} `json:"merge"`
+		} `json:"config"`
+	} `json:"ignition"`
+	Passwd struct {
+		Users []struct {
+			Name              string   `json:"name"`
+			SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+		} `json:"users,omitempty"`
+	} `json:"passwd,omitempty"`
+	Storage struct {
+		Files []json.RawMessage `json:"files,omitempty"`
+	} `json:"storage,omitempty"`
+}
+
+// detectIgnitionSpecVersion inspects the ignition.version field of a raw
+// JSON payload and reports whether it is a spec 2.x or 3.x config.
+func detectIgnitionSpecVersion(raw []byte) (ignitionSpecVersion, error) {
+	var probe struct {
+		Ignition struct {
+			Version string `json:"version"`
+		} `json:"ignition"`
+	}
+	if err := json.Unmarshal(raw, &probe); err != nil {
+		return ignitionSpecUnknown, errors.Wrap(err, "failed to parse ignition config as JSON")
+	}
+	switch {
+	case strings.HasPrefix(probe.Ignition.Version, "3."):
+		return ignitionSpecV3, nil
+	case strings.HasPrefix(probe.Ignition.Version, "2."):
+		return ignitionSpecV2, nil
+	default:
+		return ignitionSpecUnknown, fmt.Errorf("unsupported or missing ignition.version %q", probe.Ignition.Version)
+	}
+}
+
+// validateIgnitionConfig parses raw as an Ignition config and checks that
+// the fields this provider relies on are well-formed. It returns early
+// (and cheaply) so a malformed config is caught at reconcile time rather
+// than surfacing as a boot failure on the VM.
+func validateIgnitionConfig(raw []byte) (*ignitionConfig, ignitionSpecVersion, error) {
+	version, err := detectIgnitionSpecVersion(raw)
+	if err != nil {
+		return nil, ignitionSpecUnknown, err
+	}
+
+	cfg := &ignitionConfig{}
+	if err := json.Unmarshal(raw, cfg); err != nil {
+		return nil, version, errors.Wrap(err, "invalid ignition config")
+	}
+
+	for _, user := range cfg.Passwd.Users {
+		if user.Name == "" {
+			return nil, version, fmt.Errorf("invalid ignition config: passwd.users entry missing name")
+		}
+	}
+
+	return cfg, version, nil
+}
+
+// mergeProviderIgnition overlays provider-injected fragments (an SSH key,
+// a hostname, and any extra storage.files entries) from overlay on top of
+// the user-supplied config. Spec 3.x configs are patched in place as a
+// generic document so fields this provider doesn't model (systemd.units,
+// storage.directories/links/filesystems, passwd.groups,
+// passwd.users[].passwordHash, ignition.security/proxy/timeouts, ...)
+// round-trip untouched; spec 2.x configs are only validated and returned
+// unchanged, since this provider doesn't implement spec-2 merging.
+func mergeProviderIgnition(raw []byte, overlay *providerconfigv1.Ignition) ([]byte, error) {
+	version, err := detectIgnitionSpecVersion(raw)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, err := validateIgnitionConfig(raw); err != nil {
+		return nil, err
+	}
+
+	if overlay == nil || (overlay.SSHAuthorizedKey == "" && overlay.Hostname == "" && len(overlay.ExtraFiles) == 0) {
+		return raw, nil
+	}
+
+	if version == ignitionSpecV2 {
+		glog.Info("ignition spec 2.x config: skipping provider overlay merge, fields are validated only")
+		return raw, nil
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse ignition config for overlay merge")
+	}
+
+	if overlay.SSHAuthorizedKey != "" {
+		addSSHAuthorizedKey(doc, overlay.SSHAuthorizedKey)
+	}
+
+	if overlay.Hostname != "" {
+		addHostnameFile(doc, overlay.Hostname)
+	}
+
+	for _, extra := range overlay.ExtraFiles {
+		rawFile, err := json.Marshal(extra)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal provider-injected storage.files entry")
+		}
+		var file interface{}
+		if err := json.Unmarshal(rawFile, &file); err != nil {
+			return nil, errors.Wrap(err, "failed to decode provider-injected storage.files entry")
+		}
+		appendStorageFile(doc, file)
+	}
+
+	merged, err := json.Marshal(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal merged ignition config")
+	}
+	return merged, nil
+}
+
+// addSSHAuthorizedKey appends key to the "core" user's sshAuthorizedKeys
+// in doc's passwd.users, creating the user if it doesn't already exist.
+// Only the touched user entry is modified; every other passwd.users field
+// is left as decoded.
+func addSSHAuthorizedKey(doc map[string]interface{}, key string) {
+	passwd, _ := doc["passwd"].(map[string]interface{})
+	if passwd == nil {
+		passwd = map[string]interface{}{}
+		doc["passwd"] = passwd
+	}
+
+	users, _ := passwd["users"].([]interface{})
+	for _, u := range users {
+		user, ok := u.(map[string]interface{})
+		if !ok || user["name"] != "core" {
+			continue
+		}
+		keys, _ := user["sshAuthorizedKeys"].([]interface{})
+		user["sshAuthorizedKeys"] = append(keys, key)
+		return
+	}
+
+	passwd["users"] = append(users, map[string]interface{}{
+		"name":              "core",
+		"sshAuthorizedKeys": []interface{}{key},
+	})
+}
+
+// addHostnameFile writes hostname to /etc/hostname via doc's
+// storage.files, replacing any existing /etc/hostname entry so the
+// provider-supplied hostname always wins over one baked into the
+// user-supplied config.
+func addHostnameFile(doc map[string]interface{}, hostname string) {
+	storage, _ := doc["storage"].(map[string]interface{})
+	if storage == nil {
+		storage = map[string]interface{}{}
+		doc["storage"] = storage
+	}
+
+	files, _ := storage["files"].([]interface{})
+	kept := files[:0]
+	for _, f := range files {
+		file, ok := f.(map[string]interface{})
+		if ok && file["path"] == "/etc/hostname" {
+			continue
+		}
+		kept = append(kept, f)
+	}
+
+	storage["files"] = append(kept, map[string]interface{}{
+		"path": "/etc/hostname",
+		"mode": 420,
+		"contents": map[string]interface{}{
+			"source": "data:," + hostname + "%0A",
+		},
+	})
+}
+
+// appendStorageFile appends file to doc's storage.files, leaving any
+// other storage sub-trees (directories, links, filesystems) untouched.
+func appendStorageFile(doc map[string]interface{}, file interface{}) {
+	storage, _ := doc["storage"].(map[string]interface{})
+	if storage == nil {
+		storage = map[string]interface{}{}
+		doc["storage"] = storage
+	}
+
+	files, _ := storage["files"].([]interface{})
+	storage["files"] = append(files, file)
+}
+
+// isButaneConfig reports whether content looks like a Butane/FCC YAML
+// document rather than an Ignition JSON config.
+func isButaneConfig(content string) bool {
+	return strings.HasPrefix(strings.TrimSpace(content), "variant:")
+}
+
+// transpileButane converts a Butane/FCC YAML document to Ignition JSON.
+// It implements just the subset of the spec this provider needs
+// (ignition.config.merge, passwd.users[].sshAuthorizedKeys and
+// storage.files[]) rather than linking the full Butane library.
+func transpileButane(content string) ([]byte, error) {
+	var fcc struct {
+		Variant  string `yaml:"variant"`
+		Version  string `yaml:"version"`
+		Ignition struct {
+			Config struct {
+				Merge []struct {
+					Source string `yaml:"source"`
+				} `yaml:"merge,omitempty"`
+			} `yaml:"config,omitempty"`
+		} `yaml:"ignition,omitempty"`
+		Passwd struct {
+			Users []struct {
+				Name              string   `yaml:"name"`
+				SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+			} `yaml:"users,omitempty"`
+		} `yaml:"passwd,omitempty"`
+		Storage struct {
+			Files []struct {
+				Path     string `yaml:"path"`
+				Contents struct {
+					Inline string `yaml:"inline"`
+				} `yaml:"contents"`
+				Mode *int `yaml:"mode,omitempty"`
+			} `yaml:"files,omitempty"`
+		} `yaml:"storage,omitempty"`
+	}
+
+	if err := yaml.Unmarshal([]byte(content), &fcc); err != nil {
+		return nil, errors.Wrap(err, "failed to parse butane config")
+	}
+	if fcc.Variant == "" || fcc.Version == "" {
+		return nil, fmt.Errorf("invalid butane config: variant and version are required")
+	}
+
+	cfg := ignitionConfig{}
+	cfg.Ignition.Version = butaneToIgnitionVersion(fcc.Version)
+	for _, m := range fcc.Ignition.Config.Merge {
+		cfg.Ignition.Config.Merge = append(cfg.Ignition.Config.Merge, struct {
+			Source string `json:"source"`
+		}{Source: m.Source})
+	}
+	for _, user := range fcc.Passwd.Users {
+		cfg.Passwd.Users = append(cfg.Passwd.Users, struct {
+			Name              string   `json:"name"`
+			SSHAuthorizedKeys []string `json:"sshAuthorizedKeys,omitempty"`
+		}{
+			Name:              user.Name,
+			SSHAuthorizedKeys: user.SSHAuthorizedKeys,
+		})
+	}
+	for _, f := range fcc.Storage.Files {
+		file := map[string]interface{}{
+			"path": f.Path,
+			"contents": map[string]interface{}{
+				"source": "data:," + f.Contents.Inline,
+			},
+		}
+		if f.Mode != nil {
+			file["mode"] = *f.Mode
+		}
+		raw, err := json.Marshal(file)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal transpiled storage.files entry")
+		}
+		cfg.Storage.Files = append(cfg.Storage.Files, raw)
+	}
+
+	out, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal transpiled ignition config")
+	}
+	return out, nil
+}
+
+// renderIgnitionContent turns the raw contents of a userData secret into
+// the final Ignition JSON that should be uploaded: Butane documents are
+// transpiled first, the result is validated, and any provider-injected
+// fragments (SSH key, extra files, ...) are merged in. Reconcile fails
+// with a clear error rather than letting a bad config reach the VM.
+func renderIgnitionContent(raw string, overlay *providerconfigv1.Ignition) (string, error) {
+	payload := []byte(raw)
+	if isButaneConfig(raw) {
+		transpiled, err := transpileButane(raw)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to transpile butane config")
+		}
+		payload = transpiled
+	}
+
+	merged, err := mergeProviderIgnition(payload, overlay)
+	if err != nil {
+		return "", err
+	}
+
+	return string(merged), nil
+}
+
+// butaneToIgnitionVersion picks the Ignition config version for a Butane
+// spec version: every 1.x version (e.g. "1.4.0" for the fcos variant)
+// maps to "3.3.0" and any other version to "2.2.0". The variant and the
+// minor version are not taken into account.
+func butaneToIgnitionVersion(butaneVersion string) string {
+	if strings.HasPrefix(butaneVersion, "1.") {
+		return "3.3.0"
+	}
+	return "2.2.0"
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	providerconfigv1 "github.com/openshift/cluster-api-provider-libvirt/pkg/apis/libvirtproviderconfig/v1beta1"
+)
+
+func TestDetectIgnitionSpecVersion(t *testing.T) {
+	cases := []struct {
+		name    string
+		raw     string
+		want    ignitionSpecVersion
+		wantErr bool
+	}{
+		{name: "spec 3.x", raw: `{"ignition":{"version":"3.3.0"}}`, want: ignitionSpecV3},
+		{name: "spec 2.x", raw: `{"ignition":{"version":"2.2.0"}}`, want: ignitionSpecV2},
+		{name: "missing version", raw: `{"ignition":{}}`, wantErr: true},
+		{name: "invalid json", raw: `not json`, wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := detectIgnitionSpecVersion([]byte(tc.raw))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected version %v, got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestValidateIgnitionConfig(t *testing.T) {
+	valid := `{"ignition":{"version":"3.3.0"},"passwd":{"users":[{"name":"core"}]}}`
+	if _, _, err := validateIgnitionConfig([]byte(valid)); err != nil {
+		t.Fatalf("unexpected error for valid config: %v", err)
+	}
+
+	missingName := `{"ignition":{"version":"3.3.0"},"passwd":{"users":[{"sshAuthorizedKeys":["key"]}]}}`
+	if _, _, err := validateIgnitionConfig([]byte(missingName)); err == nil {
+		t.Fatalf("expected an error for a passwd.users entry missing name")
+	}
+}
+
+func TestMergeProviderIgnitionNilOverlay(t *testing.T) {
+	raw := []byte(`{"ignition":{"version":"3.3.0"}}`)
+	merged, err := mergeProviderIgnition(raw, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(merged) != string(raw) {
+		t.Fatalf("expected a nil overlay to leave the config unchanged")
+	}
+}
+
+func TestMergeProviderIgnitionSpecV2SkipsMerge(t *testing.T) {
+	raw := []byte(`{"ignition":{"version":"2.2.0"},"passwd":{"users":[{"name":"core"}]}}`)
+	overlay := &providerconfigv1.Ignition{SSHAuthorizedKey: "ssh-rsa AAAA"}
+
+	merged, err := mergeProviderIgnition(raw, overlay)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(merged) != string(raw) {
+		t.Fatalf("expected a spec 2.x config to be returned unchanged")
+	}
+}
+
+func TestMergeProviderIgnitionSSHAndHostname(t *testing.T) {
+	raw := []byte(`{
+		"ignition": {"version": "3.3.0"},
+		"passwd": {"users": [{"name": "core", "sshAuthorizedKeys": ["existing-key"]}]},
+		"storage": {"directories": [{"path": "/var/lib/example"}]}
+	}`)
+	overlay := &providerconfigv1.Ignition{
+		SSHAuthorizedKey: "ssh-rsa provider-key",
+		Hostname:         "worker-0",
+	}
+
+	merged, err := mergeProviderIgnition(raw, overlay)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(merged, &doc); err != nil {
+		t.Fatalf("merged config is not valid JSON: %v", err)
+	}
+
+	storage := doc["storage"].(map[string]interface{})
+	if _, ok := storage["directories"]; !ok {
+		t.Fatalf("expected unmodeled storage.directories to round-trip untouched, got %+v", storage)
+	}
+
+	users := doc["passwd"].(map[string]interface{})["users"].([]interface{})
+	user := users[0].(map[string]interface{})
+	keys := user["sshAuthorizedKeys"].([]interface{})
+	if len(keys) != 2 || keys[0] != "existing-key" || keys[1] != "ssh-rsa provider-key" {
+		t.Fatalf("expected the provider key to be appended to the existing keys, got %+v", keys)
+	}
+
+	files := storage["files"].([]interface{})
+	if len(files) != 1 {
+		t.Fatalf("expected exactly one storage.files entry for the hostname overlay, got %d", len(files))
+	}
+	hostnameFile := files[0].(map[string]interface{})
+	if hostnameFile["path"] != "/etc/hostname" {
+		t.Fatalf("expected a /etc/hostname file, got %+v", hostnameFile)
+	}
+	contents := hostnameFile["contents"].(map[string]interface{})
+	if !strings.Contains(contents["source"].(string), "worker-0") {
+		t.Fatalf("expected /etc/hostname contents to contain the overlay hostname, got %+v", contents)
+	}
+}
+
+func TestAddHostnameFileReplacesExisting(t *testing.T) {
+	doc := map[string]interface{}{
+		"storage": map[string]interface{}{
+			"files": []interface{}{
+				map[string]interface{}{"path": "/etc/hostname", "contents": map[string]interface{}{"source": "data:,old"}},
+				map[string]interface{}{"path": "/etc/other"},
+			},
+		},
+	}
+
+	addHostnameFile(doc, "worker-1")
+
+	files := doc["storage"].(map[string]interface{})["files"].([]interface{})
+	if len(files) != 2 {
+		t.Fatalf("expected the existing /etc/hostname entry to be replaced, not duplicated, got %d files", len(files))
+	}
+
+	var sawOther, sawHostname bool
+	for _, f := range files {
+		file := f.(map[string]interface{})
+		switch file["path"] {
+		case "/etc/other":
+			sawOther = true
+		case "/etc/hostname":
+			sawHostname = true
+			contents := file["contents"].(map[string]interface{})
+			if strings.Contains(contents["source"].(string), "old") {
+				t.Fatalf("expected the old /etc/hostname contents to be replaced, got %+v", contents)
+			}
+		}
+	}
+	if !sawOther || !sawHostname {
+		t.Fatalf("expected both /etc/other and /etc/hostname to be present, got %+v", files)
+	}
+}
+
+func TestAppendStorageFile(t *testing.T) {
+	doc := map[string]interface{}{
+		"storage": map[string]interface{}{
+			"directories": []interface{}{map[string]interface{}{"path": "/var/lib/example"}},
+		},
+	}
+
+	appendStorageFile(doc, map[string]interface{}{"path": "/etc/example"})
+
+	storage := doc["storage"].(map[string]interface{})
+	if _, ok := storage["directories"]; !ok {
+		t.Fatalf("expected storage.directories to be left untouched")
+	}
+	files := storage["files"].([]interface{})
+	if len(files) != 1 || files[0].(map[string]interface{})["path"] != "/etc/example" {
+		t.Fatalf("expected the new file to be appended to storage.files, got %+v", files)
+	}
+}
+
+func TestTranspileButane(t *testing.T) {
+	fcc := `
+variant: fcos
+version: 1.4.0
+passwd:
+  users:
+    - name: core
+      ssh_authorized_keys:
+        - ssh-rsa AAAA
+storage:
+  files:
+    - path: /etc/example
+      contents:
+        inline: hello
+`
+	out, err := transpileButane(fcc)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("transpiled output is not valid JSON: %v", err)
+	}
+	if doc["ignition"].(map[string]interface{})["version"] != "3.3.0" {
+		t.Fatalf("expected a 1.x butane variant to transpile to ignition 3.3.0, got %+v", doc["ignition"])
+	}
+}
+
+func TestTranspileButaneMissingVariantOrVersion(t *testing.T) {
+	if _, err := transpileButane("passwd:\n  users: []\n"); err == nil {
+		t.Fatalf("expected an error for a butane document missing variant/version")
+	}
+}
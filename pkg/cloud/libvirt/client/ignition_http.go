@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/openshift/cluster-api-provider-libvirt/pkg/cloud/libvirt/client/ignitionserver"
+)
+
+// setIgnitionHTTP registers content with the shared ignition HTTP server
+// and points the guest at the resulting signed, one-time URL instead of
+// uploading a volume to the libvirt pool. This keeps secrets embedded in
+// userData (bootstrap kubeconfig, TLS material, ...) off disk in the
+// pool, and isn't bound by fw_cfg's practical size limit on the
+// rendered config.
+//
+// injector is the same per-architecture IgnitionInjector setIgnition(ForS390X)
+// would otherwise use to deliver the full config: on platforms where
+// that's fwCfgInjector, the URL is passed straight through fw_cfg; on
+// platforms where fw_cfg isn't available (s390x), a tiny stub ignition
+// config pointing config.merge at the signed URL is written into the
+// guest filesystem through injector instead, so Ignition fetches and
+// merges the real config itself on first boot.
+func setIgnitionHTTP(domainDef *libvirtxml.Domain, server *ignitionserver.Server, injector IgnitionInjector, targetPath, machineUID, content string) error {
+	if server == nil {
+		return fmt.Errorf("ignition delivery mode \"http\" requires an ignition HTTP server to be configured")
+	}
+	if machineUID == "" {
+		return fmt.Errorf("ignition delivery mode \"http\" requires the machine UID")
+	}
+
+	url, err := server.Register(machineUID, []byte(content))
+	if err != nil {
+		return err
+	}
+
+	glog.Infof("Registered ignition config for machine %s at %s", machineUID, url)
+
+	if _, fwCfg := injector.(*fwCfgInjector); fwCfg {
+		domainDef.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{
+			Args: []libvirtxml.DomainQEMUCommandlineArg{
+				{
+					Value: "-fw_cfg",
+				},
+				{
+					Value: fmt.Sprintf("name=opt/com.coreos/config.url,string=%s", url),
+				},
+			},
+		}
+		return nil
+	}
+
+	pointerPath, err := writeIgnitionURLPointer(url)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.Remove(pointerPath); err != nil {
+			glog.Infof("Error while removing tmp ignition URL pointer file: %s", err)
+		}
+	}()
+
+	return injector.Inject(domainDef, pointerPath, targetPath)
+}
+
+// writeIgnitionURLPointer writes a minimal ignition config to a temp
+// file that merges in the config served at url, for delivery through an
+// injector that writes directly into the guest filesystem rather than
+// fw_cfg.
+func writeIgnitionURLPointer(url string) (string, error) {
+	pointer := fmt.Sprintf(`{"ignition":{"version":"3.3.0","config":{"merge":[{"source":%q}]}}}`, url)
+
+	tempFile, err := ioutil.TempFile("", "ignition-url-pointer")
+	if err != nil {
+		return "", fmt.Errorf("cannot create tmp file for ignition URL pointer: %s", err)
+	}
+	defer tempFile.Close()
+
+	if _, err := tempFile.WriteString(pointer); err != nil {
+		return "", fmt.Errorf("cannot write ignition URL pointer to temporary file: %s", err)
+	}
+
+	return tempFile.Name(), nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	"github.com/openshift/cluster-api-provider-libvirt/pkg/cloud/libvirt/client/ignitionserver"
+)
+
+func TestSetIgnitionHTTPFwCfg(t *testing.T) {
+	server := ignitionserver.NewServer("https://10.0.0.5:8443", []byte("secret"))
+	domainDef := &libvirtxml.Domain{}
+
+	if err := setIgnitionHTTP(domainDef, server, &fwCfgInjector{}, defaultIgnitionTargetPath, "machine-1", "ignition content"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if domainDef.QEMUCommandline == nil || len(domainDef.QEMUCommandline.Args) != 2 {
+		t.Fatalf("expected fw_cfg to set two QEMU commandline args, got %+v", domainDef.QEMUCommandline)
+	}
+	configArg := domainDef.QEMUCommandline.Args[1].Value
+	if !strings.Contains(configArg, "name=opt/com.coreos/config.url,string=") {
+		t.Fatalf("expected QEMU arg to set config.url, got %q", configArg)
+	}
+	if !strings.Contains(configArg, "https://10.0.0.5:8443/ignition/machine-1?token=") {
+		t.Fatalf("expected QEMU arg to reference the registered signed URL, got %q", configArg)
+	}
+}
+
+func TestSetIgnitionHTTPInjector(t *testing.T) {
+	server := ignitionserver.NewServer("https://10.0.0.5:8443", []byte("secret"))
+	domainDef := &libvirtxml.Domain{}
+	injector := &fakeInjector{}
+
+	if err := setIgnitionHTTP(domainDef, server, injector, defaultIgnitionTargetPath, "machine-1", "ignition content"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !injector.called {
+		t.Fatalf("expected the injector to be called")
+	}
+	if injector.targetPath != defaultIgnitionTargetPath {
+		t.Fatalf("expected target path %q, got %q", defaultIgnitionTargetPath, injector.targetPath)
+	}
+
+	pointer, err := injector.ignitionContent, injector.ignitionReadErr
+	if err != nil {
+		t.Fatalf("expected the pointer file %q passed to the injector to be readable while it ran: %v", injector.ignition, err)
+	}
+	if !strings.Contains(string(pointer), "https://10.0.0.5:8443/ignition/machine-1?token=") {
+		t.Fatalf("expected pointer config to merge in the signed URL, got %q", pointer)
+	}
+	if !strings.Contains(string(pointer), `"version":"3.3.0"`) {
+		t.Fatalf("expected pointer config to be a spec 3.3.0 document, got %q", pointer)
+	}
+}
+
+func TestSetIgnitionHTTPRequiresServer(t *testing.T) {
+	domainDef := &libvirtxml.Domain{}
+
+	err := setIgnitionHTTP(domainDef, nil, &fwCfgInjector{}, defaultIgnitionTargetPath, "machine-1", "ignition content")
+	if err == nil {
+		t.Fatalf("expected an error when no ignition HTTP server is configured")
+	}
+}
+
+func TestSetIgnitionHTTPRequiresMachineUID(t *testing.T) {
+	server := ignitionserver.NewServer("https://10.0.0.5:8443", []byte("secret"))
+	domainDef := &libvirtxml.Domain{}
+
+	err := setIgnitionHTTP(domainDef, server, &fwCfgInjector{}, defaultIgnitionTargetPath, "", "ignition content")
+	if err == nil {
+		t.Fatalf("expected an error when machineUID is empty")
+	}
+}
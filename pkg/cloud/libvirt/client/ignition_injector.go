@@ -0,0 +1,193 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	providerconfigv1 "github.com/openshift/cluster-api-provider-libvirt/pkg/apis/libvirtproviderconfig/v1beta1"
+)
+
+// defaultIgnitionTargetPath is the in-guest path the rendered ignition
+// config is written to, unless overridden per-distro.
+const defaultIgnitionTargetPath = "/ignition/config.ign"
+
+// IgnitionInjector delivers an ignition config file on the host to a
+// domain. Implementations differ in how they get the config onto (or in
+// front of) the guest: some attach it as a QEMU device, others write it
+// directly into the guest filesystem before first boot.
+type IgnitionInjector interface {
+	// Inject makes ignitionPath, the host path of an ignition config (the
+	// volume uploaded to the libvirt pool, or a temporary URL pointer
+	// config in HTTP delivery mode), available to domainDef at
+	// targetPath. targetPath is only meaningful to injectors that write into the guest
+	// filesystem; fw_cfg-based injectors ignore it.
+	Inject(domainDef *libvirtxml.Domain, ignitionPath, targetPath string) error
+}
+
+// ignitionTargetPath returns the in-guest path the ignition config should
+// be written to, defaulting to the path RHCOS/FCOS expect.
+func ignitionTargetPath(ignition *providerconfigv1.Ignition) string {
+	if ignition.TargetPath != "" {
+		return ignition.TargetPath
+	}
+	return defaultIgnitionTargetPath
+}
+
+// ignitionInjectorForArch picks the IgnitionInjector to use. backend, when
+// set, is an explicit override (one of "fw_cfg", "libguestfs", "nbd" or
+// "guestfish"). Otherwise the provider defaults to fw_cfg on platforms
+// that support it, and on s390x (where fw_cfg isn't supported) prefers
+// libguestfs when the binary was built with it, falling back to nbd.
+// guestfish, with its sudo requirement and fragile stdout parsing, is
+// kept only as an explicit opt-in.
+func ignitionInjectorForArch(arch, backend string) (IgnitionInjector, error) {
+	if backend == "" {
+		switch {
+		case arch != "s390x":
+			backend = "fw_cfg"
+		case libguestfsAvailable:
+			backend = "libguestfs"
+		default:
+			backend = "nbd"
+		}
+	}
+
+	switch backend {
+	case "fw_cfg":
+		return &fwCfgInjector{}, nil
+	case "libguestfs":
+		return &libguestfsInjector{}, nil
+	case "nbd":
+		return &nbdInjector{}, nil
+	case "guestfish":
+		return &guestfishInjector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown ignition injection backend %q", backend)
+	}
+}
+
+// fwCfgInjector passes the ignition config to the guest via QEMU's
+// fw_cfg device. This is the current x86_64 path: CoreOS-family guests
+// read opt/com.coreos/config out of fw_cfg at boot, so nothing needs to
+// be written into the guest filesystem ahead of time.
+type fwCfgInjector struct{}
+
+func (i *fwCfgInjector) Inject(domainDef *libvirtxml.Domain, ignitionPath, targetPath string) error {
+	domainDef.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{
+		Args: []libvirtxml.DomainQEMUCommandlineArg{
+			{
+				// https://github.com/qemu/qemu/blob/master/docs/specs/fw_cfg.txt
+				Value: "-fw_cfg",
+			},
+			{
+				Value: fmt.Sprintf("name=opt/com.coreos/config,file=%s", ignitionPath),
+			},
+		},
+	}
+	return nil
+}
+
+// guestfishInjector writes the ignition config into the guest filesystem
+// by driving the `guestfish` CLI through its `--listen`/`--remote`
+// protocol. It requires passwordless sudo on the controller and is only
+// used when the "guestfish" backend is selected explicitly.
+type guestfishInjector struct{}
+
+func (i *guestfishInjector) Inject(domainDef *libvirtxml.Domain, ignitionPath, targetPath string) error {
+	glog.Info("Injecting ignition configuration using guestfish")
+
+	/*
+	 * Add the image into guestfish, execute the following command,
+	 *     guestfish --listen -a ${volumeFilePath}
+	 *
+	 * output example:
+	 *  	   GUESTFISH_PID=4513; export GUESTFISH_PID
+	 */
+	args := []string{"--listen", "-a", domainDef.Devices.Disks[0].Source.File.File}
+	output, err := startCmd(true, nil, args...)
+	if err != nil {
+		return err
+	}
+
+	strArray := strings.Split(output, ";")
+	if len(strArray) != 2 {
+		return fmt.Errorf("Invalid output when starting guestfish: %s", output)
+	}
+	strArray1 := strings.Split(strArray[0], "=")
+	if len(strArray1) != 2 {
+		return fmt.Errorf("failed to get the guestfish PID from %s", output)
+	}
+	env := []string{strArray[0]}
+
+	/*
+	 * Launch guestfish, execute the following command,
+	 *     guestfish --remote -- run
+	 */
+	args = []string{"--remote", "--", "run"}
+	_, err = execCmd(true, env, args...)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Get the boot filesystem, execute the following command,
+	 *     findfs-label boot
+	 *
+	 *	output example:
+	 *		/dev/sda1
+	 */
+	args = []string{"--remote", "--", "findfs-label", "boot"}
+	output, err = execCmd(true, env, args...)
+	if err != nil {
+		return err
+	}
+
+	bootDisk := strings.TrimSpace(output)
+	if len(bootDisk) == 0 {
+		return fmt.Errorf("failed to get the boot filesystem")
+	}
+
+	/*
+	 * Mount the boot filesystem, execute the following command,
+	 *     guestfish --remote -- mount ${boot_filesystem} /
+	 */
+	args = []string{"--remote", "--", "mount", bootDisk, "/"}
+	_, err = execCmd(true, env, args...)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Upload the ignition file, execute the following command,
+	 *     guestfish --remote -- upload ${ignition_filepath} ${targetPath}
+	 */
+	args = []string{"--remote", "--", "upload", ignitionPath, targetPath}
+	_, err = execCmd(true, env, args...)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Umount all filesystems, execute the following command,
+	 *     guestfish --remote -- umount-all
+	 */
+	args = []string{"--remote", "--", "umount-all"}
+	_, err = execCmd(true, env, args...)
+	if err != nil {
+		return err
+	}
+
+	/*
+	 * Exit guestfish, execute the following command,
+	 *     guestfish --remote -- exit
+	 */
+	args = []string{"--remote", "--", "exit"}
+	_, err = execCmd(true, env, args...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
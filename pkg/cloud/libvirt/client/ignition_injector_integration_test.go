@@ -0,0 +1,132 @@
+// +build integration
+
+package client
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// TestIgnitionInjectorsIntegration exercises the guestfish and nbd
+// injectors against a real disk image with a label="boot" partition, the
+// way they'll actually run in production. It requires root, `guestfish`,
+// `qemu-img`, `qemu-nbd`, `parted` and `mkfs.vfat` on PATH, so it's gated
+// behind the "integration" build tag rather than running as part of the
+// normal `go test ./...` suite.
+func TestIgnitionInjectorsIntegration(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("integration test requires root to create loop/nbd devices")
+	}
+
+	injectors := map[string]IgnitionInjector{
+		"guestfish": &guestfishInjector{},
+		"nbd":       &nbdInjector{},
+	}
+
+	for name, injector := range injectors {
+		injector := injector
+		t.Run(name, func(t *testing.T) {
+			diskPath := createTestBootDisk(t)
+			defer os.Remove(diskPath)
+
+			domainDef := &libvirtxml.Domain{
+				Devices: &libvirtxml.DomainDeviceList{
+					Disks: []libvirtxml.DomainDisk{
+						{
+							Source: &libvirtxml.DomainDiskSource{
+								File: &libvirtxml.DomainDiskSourceFile{File: diskPath},
+							},
+						},
+					},
+				},
+			}
+
+			ignitionContent := []byte(`{"ignition":{"version":"3.3.0"}}`)
+			ignitionFile, err := ioutil.TempFile("", "integration-ignition")
+			if err != nil {
+				t.Fatalf("failed to create ignition temp file: %v", err)
+			}
+			defer os.Remove(ignitionFile.Name())
+			if _, err := ignitionFile.Write(ignitionContent); err != nil {
+				t.Fatalf("failed to write ignition temp file: %v", err)
+			}
+			ignitionFile.Close()
+
+			if err := injector.Inject(domainDef, ignitionFile.Name(), defaultIgnitionTargetPath); err != nil {
+				t.Fatalf("%s injector failed: %v", name, err)
+			}
+
+			got := readFileFromTestBootDisk(t, diskPath, defaultIgnitionTargetPath)
+			if string(got) != string(ignitionContent) {
+				t.Fatalf("%s injector wrote %q, want %q", name, got, ignitionContent)
+			}
+		})
+	}
+}
+
+// createTestBootDisk creates a small raw disk image with a single
+// FAT32 partition labelled "boot", the label the injectors look up.
+func createTestBootDisk(t *testing.T) string {
+	t.Helper()
+
+	disk, err := ioutil.TempFile("", "integration-disk")
+	if err != nil {
+		t.Fatalf("failed to create disk temp file: %v", err)
+	}
+	disk.Close()
+
+	mustRun(t, "qemu-img", "create", "-f", "raw", disk.Name(), "64M")
+	mustRun(t, "parted", "--script", disk.Name(), "mklabel", "gpt", "mkpart", "boot", "fat32", "1MiB", "63MiB")
+
+	loopDevice := mustRun(t, "losetup", "--show", "-f", "-P", disk.Name())
+	defer mustRun(t, "losetup", "-d", loopDevice)
+
+	mustRun(t, "mkfs.vfat", "-n", "boot", loopDevice+"p1")
+
+	return disk.Name()
+}
+
+// readFileFromTestBootDisk mounts the boot-labelled partition on disk
+// and returns the contents of path, for asserting what an injector wrote.
+func readFileFromTestBootDisk(t *testing.T, disk, path string) []byte {
+	t.Helper()
+
+	loopDevice := mustRun(t, "losetup", "--show", "-f", "-P", disk)
+	defer mustRun(t, "losetup", "-d", loopDevice)
+
+	mountDir, err := ioutil.TempDir("", "integration-mount")
+	if err != nil {
+		t.Fatalf("failed to create mount dir: %v", err)
+	}
+	defer os.RemoveAll(mountDir)
+
+	mustRun(t, "mount", loopDevice+"p1", mountDir)
+	defer mustRun(t, "umount", mountDir)
+
+	content, err := ioutil.ReadFile(filepath.Join(mountDir, path))
+	if err != nil {
+		t.Fatalf("failed to read %s from boot disk: %v", path, err)
+	}
+	return content
+}
+
+func mustRun(t *testing.T, name string, args ...string) string {
+	t.Helper()
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		t.Fatalf("command '%s %v' failed: %v: %s", name, args, err, out)
+	}
+	return trimNewline(string(out))
+}
+
+func trimNewline(s string) string {
+	for len(s) > 0 && (s[len(s)-1] == '\n' || s[len(s)-1] == '\r') {
+		s = s[:len(s)-1]
+	}
+	return s
+}
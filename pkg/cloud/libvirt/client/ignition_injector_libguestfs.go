@@ -0,0 +1,56 @@
+// +build libguestfs
+
+package client
+
+import (
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+
+	guestfs "github.com/libguestfs/libguestfs/golang/src"
+)
+
+// libguestfsAvailable reports whether this binary was built with the
+// "libguestfs" tag, so ignitionInjectorForArch can prefer it over nbd on
+// s390x when it's actually usable.
+const libguestfsAvailable = true
+
+// libguestfsInjector writes the ignition config into the guest
+// filesystem using the in-process libguestfs Go bindings instead of
+// shelling out to the `guestfish` CLI. This avoids the sudo requirement
+// and the stdout-parsing fragility of the guestfish injector, at the
+// cost of requiring the libguestfs appliance to be installed wherever
+// the provider runs. Gated behind the "libguestfs" build tag since the
+// bindings need cgo and the libguestfs shared libraries at build time.
+type libguestfsInjector struct{}
+
+func (i *libguestfsInjector) Inject(domainDef *libvirtxml.Domain, ignitionPath, targetPath string) error {
+	glog.Info("Injecting ignition configuration using libguestfs")
+
+	g, err := guestfs.Create()
+	if err != nil {
+		return err
+	}
+	defer g.Close()
+
+	diskPath := domainDef.Devices.Disks[0].Source.File.File
+	if err := g.Add_drive(diskPath, nil); err != nil {
+		return err
+	}
+	if err := g.Launch(); err != nil {
+		return err
+	}
+
+	bootDisk, err := g.Findfs_label("boot")
+	if err != nil {
+		return err
+	}
+	if err := g.Mount(bootDisk, "/"); err != nil {
+		return err
+	}
+
+	if err := g.Upload(ignitionPath, targetPath); err != nil {
+		return err
+	}
+
+	return g.Umount_all()
+}
@@ -0,0 +1,123 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	"github.com/pkg/errors"
+)
+
+// nbdInjector writes the ignition config into the guest filesystem by
+// connecting the disk image as a network block device via `qemu-nbd` and
+// mounting the nbd device's boot partition. It's a fallback for
+// environments where libguestfs isn't available. It does not invoke sudo
+// itself, so the provider process must be able to run `qemu-nbd --connect`
+// and `mount` directly, and the nbd kernel module must already be loaded.
+type nbdInjector struct{}
+
+func (i *nbdInjector) Inject(domainDef *libvirtxml.Domain, ignitionPath, targetPath string) error {
+	glog.Info("Injecting ignition configuration using qemu-nbd")
+
+	diskPath := domainDef.Devices.Disks[0].Source.File.File
+
+	nbdDevice, err := firstFreeNBDDevice()
+	if err != nil {
+		return err
+	}
+
+	if _, err := runCmd("qemu-nbd", "--connect="+nbdDevice, diskPath); err != nil {
+		return errors.Wrapf(err, "failed to connect %s via qemu-nbd", diskPath)
+	}
+	defer func() {
+		if _, err := runCmd("qemu-nbd", "--disconnect", nbdDevice); err != nil {
+			glog.Infof("Error disconnecting %s: %s", nbdDevice, err)
+		}
+	}()
+
+	bootPartition, err := waitForPartitionLabel(nbdDevice, "boot")
+	if err != nil {
+		return err
+	}
+
+	mountDir, err := ioutil.TempDir("", "cluster-api-provider-libvirt-nbd")
+	if err != nil {
+		return errors.Wrap(err, "failed to create mount directory for nbd device")
+	}
+	defer os.RemoveAll(mountDir)
+
+	if _, err := runCmd("mount", bootPartition, mountDir); err != nil {
+		return errors.Wrapf(err, "failed to mount %s on %s", bootPartition, mountDir)
+	}
+	defer func() {
+		if _, err := runCmd("umount", mountDir); err != nil {
+			glog.Infof("Error unmounting %s: %s", mountDir, err)
+		}
+	}()
+
+	dest := filepath.Join(mountDir, targetPath)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return errors.Wrapf(err, "failed to create parent directories for %s", dest)
+	}
+
+	ignitionContent, err := ioutil.ReadFile(ignitionPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read ignition config %s", ignitionPath)
+	}
+	if err := ioutil.WriteFile(dest, ignitionContent, 0644); err != nil {
+		return errors.Wrapf(err, "failed to write ignition config to %s", dest)
+	}
+
+	return nil
+}
+
+// waitForPartitionLabel resolves the partition on nbdDevice labelled
+// label, the same way guestfishInjector/libguestfsInjector resolve the
+// boot filesystem via findfs-label, instead of assuming a fixed
+// partition number. CoreOS-family layouts put the ESP/BIOS-BOOT
+// partitions at p1/p2 and the "boot" filesystem at p3.
+func waitForPartitionLabel(nbdDevice, label string) (string, error) {
+	out, err := runCmd("blkid", "-L", label)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to find partition labelled %q", label)
+	}
+
+	partition := strings.TrimSpace(out)
+	if !strings.HasPrefix(partition, nbdDevice) {
+		return "", fmt.Errorf("partition labelled %q (%s) is not on %s", label, partition, nbdDevice)
+	}
+	return partition, nil
+}
+
+// firstFreeNBDDevice returns the first /dev/nbdN device not currently in
+// use. qemu-nbd requires the caller to pick a free device explicitly.
+func firstFreeNBDDevice() (string, error) {
+	for n := 0; n < 16; n++ {
+		device := fmt.Sprintf("/dev/nbd%d", n)
+		sizePath := fmt.Sprintf("/sys/class/block/nbd%d/size", n)
+		size, err := ioutil.ReadFile(sizePath)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(size)) == "0" {
+			return device, nil
+		}
+	}
+	return "", fmt.Errorf("no free /dev/nbdN device found; is the nbd kernel module loaded with enough devices?")
+}
+
+func runCmd(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	glog.Infof("Running: %v %v", cmd.Path, cmd.Args)
+	out, err := cmd.CombinedOutput()
+	glog.Infof("Ran: %v %v Output: %v", cmd.Path, cmd.Args, string(out))
+	if err != nil {
+		err = errors.Wrapf(err, "error running command '%v %v'", cmd.Path, strings.Join(cmd.Args, " "))
+	}
+	return string(out), err
+}
@@ -0,0 +1,23 @@
+// +build !libguestfs
+
+package client
+
+import (
+	"fmt"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+// libguestfsAvailable reports whether this binary was built with the
+// "libguestfs" tag. See ignition_injector_libguestfs.go.
+const libguestfsAvailable = false
+
+// libguestfsInjector is a stub used when the provider is built without
+// the "libguestfs" tag: the real implementation needs cgo and the
+// libguestfs appliance at build time, so binaries built without them
+// fail clearly instead of silently falling back to another backend.
+type libguestfsInjector struct{}
+
+func (i *libguestfsInjector) Inject(domainDef *libvirtxml.Domain, ignitionPath, targetPath string) error {
+	return fmt.Errorf("ignition injection backend %q requires a binary built with the \"libguestfs\" build tag", "libguestfs")
+}
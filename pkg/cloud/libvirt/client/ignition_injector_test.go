@@ -0,0 +1,89 @@
+package client
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+	providerconfigv1 "github.com/openshift/cluster-api-provider-libvirt/pkg/apis/libvirtproviderconfig/v1beta1"
+)
+
+// fakeInjector records the arguments it was called with, and the
+// contents of ignitionPath at call time, so tests can assert on what
+// setIgnition/setIgnitionForS390X pass down without touching libvirt.
+type fakeInjector struct {
+	called          bool
+	domainDef       *libvirtxml.Domain
+	ignition        string
+	ignitionContent []byte
+	ignitionReadErr error
+	targetPath      string
+	err             error
+}
+
+func (f *fakeInjector) Inject(domainDef *libvirtxml.Domain, ignitionPath, targetPath string) error {
+	f.called = true
+	f.domainDef = domainDef
+	f.ignition = ignitionPath
+	f.ignitionContent, f.ignitionReadErr = ioutil.ReadFile(ignitionPath)
+	f.targetPath = targetPath
+	return f.err
+}
+
+func TestFakeInjectorSatisfiesInterface(t *testing.T) {
+	var f fakeInjector
+	var injector IgnitionInjector = &f
+
+	if err := injector.Inject(&libvirtxml.Domain{}, "/tmp/config.ign", "/ignition/config.ign"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !f.called || f.ignition != "/tmp/config.ign" || f.targetPath != "/ignition/config.ign" {
+		t.Fatalf("fakeInjector did not record the call as expected: %+v", f)
+	}
+}
+
+func TestIgnitionInjectorForArch(t *testing.T) {
+	cases := []struct {
+		name    string
+		arch    string
+		backend string
+		want    IgnitionInjector
+		wantErr bool
+	}{
+		{name: "defaults to fw_cfg on x86_64", arch: "amd64", backend: "", want: &fwCfgInjector{}},
+		{name: "defaults to nbd on s390x without libguestfs", arch: "s390x", backend: "", want: &nbdInjector{}},
+		{name: "explicit backend overrides arch default", arch: "s390x", backend: "guestfish", want: &guestfishInjector{}},
+		{name: "explicit nbd backend", arch: "s390x", backend: "nbd", want: &nbdInjector{}},
+		{name: "explicit libguestfs backend", arch: "s390x", backend: "libguestfs", want: &libguestfsInjector{}},
+		{name: "unknown backend is rejected", arch: "amd64", backend: "bogus", wantErr: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ignitionInjectorForArch(tc.arch, tc.backend)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got == nil {
+				t.Fatalf("expected a non-nil injector")
+			}
+			if gotType, wantType := reflect.TypeOf(got), reflect.TypeOf(tc.want); gotType != wantType {
+				t.Fatalf("expected injector of type %v, got %v", wantType, gotType)
+			}
+		})
+	}
+}
+
+func TestIgnitionTargetPathDefault(t *testing.T) {
+	ignition := &providerconfigv1.Ignition{}
+	if got := ignitionTargetPath(ignition); got != defaultIgnitionTargetPath {
+		t.Fatalf("expected default target path %q, got %q", defaultIgnitionTargetPath, got)
+	}
+}
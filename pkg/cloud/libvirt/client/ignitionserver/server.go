@@ -0,0 +1,134 @@
+// Package ignitionserver serves rendered Ignition configs over HTTP(S)
+// so they never need to sit on disk in the libvirt storage pool. Each
+// config is registered under its Machine's UID behind a one-time HMAC
+// token embedded in the URL, and is deleted from memory as soon as the
+// guest fetches it.
+package ignitionserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// entry is a single ignition config pending its one-time fetch.
+type entry struct {
+	content []byte
+	token   string
+}
+
+// Server serves registered ignition configs over HTTP(S) and tracks when
+// each one was fetched, so callers can reflect that on Machine status.
+type Server struct {
+	mu        sync.Mutex
+	configs   map[string]*entry
+	fetchedAt map[string]time.Time
+	hmacKey   []byte
+	baseURL   string
+}
+
+// NewServer returns a Server that signs URLs with hmacKey and builds
+// config URLs rooted at baseURL, e.g. "https://10.0.0.5:8443".
+func NewServer(baseURL string, hmacKey []byte) *Server {
+	return &Server{
+		configs:   make(map[string]*entry),
+		fetchedAt: make(map[string]time.Time),
+		hmacKey:   hmacKey,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// Register makes content fetchable exactly once under machineUID and
+// returns the signed URL the guest should be pointed at.
+func (s *Server) Register(machineUID string, content []byte) (string, error) {
+	if machineUID == "" {
+		return "", fmt.Errorf("machineUID must not be empty")
+	}
+
+	token := s.sign(machineUID)
+
+	s.mu.Lock()
+	s.configs[machineUID] = &entry{content: content, token: token}
+	s.mu.Unlock()
+
+	return fmt.Sprintf("%s/ignition/%s?token=%s", s.baseURL, machineUID, token), nil
+}
+
+// Unregister removes any pending config and recorded fetch time for
+// machineUID. Callers should invoke this on Machine deletion so neither
+// ever outlives the Machine they were generated for.
+func (s *Server) Unregister(machineUID string) {
+	s.mu.Lock()
+	delete(s.configs, machineUID)
+	delete(s.fetchedAt, machineUID)
+	s.mu.Unlock()
+}
+
+// FetchedAt reports when machineUID's config was fetched by the guest,
+// if it has been. Callers surface this on Machine status so the
+// controller knows the node pulled its config.
+func (s *Server) FetchedAt(machineUID string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.fetchedAt[machineUID]
+	return t, ok
+}
+
+func (s *Server) sign(machineUID string) string {
+	mac := hmac.New(sha256.New, s.hmacKey)
+	mac.Write([]byte(machineUID))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Handler returns the http.Handler that serves registered configs at
+// "/ignition/<machineUID>?token=<token>".
+func (s *Server) Handler() http.Handler {
+	return http.HandlerFunc(s.serveHTTP)
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	machineUID := strings.TrimPrefix(r.URL.Path, "/ignition/")
+	token := r.URL.Query().Get("token")
+
+	s.mu.Lock()
+	e, ok := s.configs[machineUID]
+	valid := ok && hmac.Equal([]byte(token), []byte(e.token))
+	if valid {
+		// The config is one-time fetch: drop it under the same lock as the
+		// lookup so neither a replay nor a concurrent request for the same
+		// signed URL can re-read bootstrap secrets.
+		delete(s.configs, machineUID)
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !valid {
+		glog.Warningf("rejecting ignition fetch for machine %s: token mismatch", machineUID)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.coreos.ignition+json")
+	if _, err := w.Write(e.content); err != nil {
+		glog.Errorf("error writing ignition config for machine %s: %v", machineUID, err)
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	s.fetchedAt[machineUID] = now
+	s.mu.Unlock()
+
+	glog.Infof("served ignition config for machine %s at %s", machineUID, now)
+}
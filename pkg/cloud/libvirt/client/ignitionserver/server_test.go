@@ -0,0 +1,113 @@
+package ignitionserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServerFetchOnce(t *testing.T) {
+	s := NewServer("https://example.com", []byte("secret"))
+
+	url, err := s.Register("machine-1", []byte("hello ignition"))
+	if err != nil {
+		t.Fatalf("unexpected error registering config: %v", err)
+	}
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	path := url[len("https://example.com"):]
+
+	resp := mustGet(t, ts.URL+path)
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected first fetch to succeed, got status %d", resp.StatusCode)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error reading body: %v", err)
+	}
+	if string(body) != "hello ignition" {
+		t.Fatalf("expected body %q, got %q", "hello ignition", body)
+	}
+
+	if _, ok := s.FetchedAt("machine-1"); !ok {
+		t.Fatalf("expected FetchedAt to report the config as fetched")
+	}
+
+	resp = mustGet(t, ts.URL+path)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Fatalf("expected second fetch of the same URL to 404, got status %d", resp.StatusCode)
+	}
+}
+
+func TestServerRejectsWrongToken(t *testing.T) {
+	s := NewServer("https://example.com", []byte("secret"))
+
+	if _, err := s.Register("machine-1", []byte("hello ignition")); err != nil {
+		t.Fatalf("unexpected error registering config: %v", err)
+	}
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	resp := mustGet(t, ts.URL+"/ignition/machine-1?token=wrong")
+	resp.Body.Close()
+	if resp.StatusCode != 403 {
+		t.Fatalf("expected wrong token to be rejected with 403, got status %d", resp.StatusCode)
+	}
+
+	if _, ok := s.FetchedAt("machine-1"); ok {
+		t.Fatalf("expected FetchedAt to be unset after a rejected fetch")
+	}
+}
+
+func TestServerRejectsUnknownMachine(t *testing.T) {
+	s := NewServer("https://example.com", []byte("secret"))
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	resp := mustGet(t, ts.URL+"/ignition/does-not-exist?token=anything")
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Fatalf("expected unregistered machine to 404, got status %d", resp.StatusCode)
+	}
+}
+
+func TestServerUnregister(t *testing.T) {
+	s := NewServer("https://example.com", []byte("secret"))
+
+	url, err := s.Register("machine-1", []byte("hello ignition"))
+	if err != nil {
+		t.Fatalf("unexpected error registering config: %v", err)
+	}
+
+	ts := httptest.NewServer(s.Handler())
+	defer ts.Close()
+
+	path := url[len("https://example.com"):]
+
+	s.Unregister("machine-1")
+
+	resp := mustGet(t, ts.URL+path)
+	resp.Body.Close()
+	if resp.StatusCode != 404 {
+		t.Fatalf("expected unregistered machine to 404, got status %d", resp.StatusCode)
+	}
+	if _, ok := s.FetchedAt("machine-1"); ok {
+		t.Fatalf("expected FetchedAt to be cleared by Unregister")
+	}
+}
+
+func mustGet(t *testing.T, url string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("unexpected error fetching %s: %v", url, err)
+	}
+	return resp
+}
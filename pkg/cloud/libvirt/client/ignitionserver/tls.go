@@ -0,0 +1,115 @@
+package ignitionserver
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	tlsCertSecretKey = "tls.crt"
+	tlsKeySecretKey  = "tls.key"
+
+	certValidity = 365 * 24 * time.Hour
+)
+
+// EnsureServingCertificate returns the TLS certificate the ignition
+// server should present for hosts, loading it from the Secret
+// namespace/secretName if one already exists or minting and persisting a
+// new self-signed certificate otherwise. Reusing the stored certificate
+// across controller restarts means guests never see the CA change out
+// from under them mid-fleet.
+func EnsureServingCertificate(kubeClient kubernetes.Interface, namespace, secretName string, hosts []string) (tls.Certificate, error) {
+	existing, getErr := kubeClient.CoreV1().Secrets(namespace).Get(secretName, metav1.GetOptions{})
+	if getErr == nil {
+		if cert, certErr := tls.X509KeyPair(existing.Data[tlsCertSecretKey], existing.Data[tlsKeySecretKey]); certErr == nil {
+			return cert, nil
+		}
+		glog.Warningf("existing ignition server certificate secret '%v/%v' is invalid, regenerating", namespace, secretName)
+	}
+
+	certPEM, keyPEM, err := generateSelfSignedCert(hosts)
+	if err != nil {
+		return tls.Certificate{}, errors.Wrap(err, "failed to generate self-signed ignition server certificate")
+	}
+
+	if getErr == nil {
+		existing.Data = map[string][]byte{
+			tlsCertSecretKey: certPEM,
+			tlsKeySecretKey:  keyPEM,
+		}
+		if _, err := kubeClient.CoreV1().Secrets(namespace).Update(existing); err != nil {
+			return tls.Certificate{}, errors.Wrapf(err, "failed to store regenerated certificate in secret '%v/%v'", namespace, secretName)
+		}
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      secretName,
+			Namespace: namespace,
+		},
+		Type: corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			tlsCertSecretKey: certPEM,
+			tlsKeySecretKey:  keyPEM,
+		},
+	}
+	if _, err := kubeClient.CoreV1().Secrets(namespace).Create(secret); err != nil {
+		return tls.Certificate{}, errors.Wrapf(err, "failed to store generated certificate in secret '%v/%v'", namespace, secretName)
+	}
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func generateSelfSignedCert(hosts []string) ([]byte, []byte, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "cluster-api-provider-libvirt-ignitionserver"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(certValidity),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,46 @@
+package ignitionserver
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestEnsureServingCertificateGeneratesAndPersists(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+
+	cert, err := EnsureServingCertificate(kubeClient, "openshift-machine-api", "ignition-server-tls", []string{"10.0.0.5"})
+	if err != nil {
+		t.Fatalf("unexpected error generating certificate: %v", err)
+	}
+	if len(cert.Certificate) == 0 {
+		t.Fatalf("expected a non-empty certificate chain")
+	}
+
+	secret, err := kubeClient.CoreV1().Secrets("openshift-machine-api").Get("ignition-server-tls", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected certificate to be persisted to a secret: %v", err)
+	}
+	if len(secret.Data[tlsCertSecretKey]) == 0 || len(secret.Data[tlsKeySecretKey]) == 0 {
+		t.Fatalf("expected secret to contain both %q and %q", tlsCertSecretKey, tlsKeySecretKey)
+	}
+}
+
+func TestEnsureServingCertificateReusesExisting(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+
+	first, err := EnsureServingCertificate(kubeClient, "openshift-machine-api", "ignition-server-tls", []string{"10.0.0.5"})
+	if err != nil {
+		t.Fatalf("unexpected error generating certificate: %v", err)
+	}
+
+	second, err := EnsureServingCertificate(kubeClient, "openshift-machine-api", "ignition-server-tls", []string{"10.0.0.5"})
+	if err != nil {
+		t.Fatalf("unexpected error reusing certificate: %v", err)
+	}
+
+	if string(first.Certificate[0]) != string(second.Certificate[0]) {
+		t.Fatalf("expected the second call to reuse the persisted certificate instead of minting a new one")
+	}
+}